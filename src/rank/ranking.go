@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// RankSet is a mutex protected leaderboard: userID -> score, plus dirty
+// bookkeeping so persistence_task can flush only what changed since the
+// last tick instead of re-marshaling the whole set.
+type RankSet struct {
+	sync.RWMutex
+	scores map[int32]int32
+	dirty  map[int32]bool // userID -> alive (true: put, false: delete)
+}
+
+func NewRankSet() *RankSet {
+	return &RankSet{
+		scores: make(map[int32]int32),
+		dirty:  make(map[int32]bool),
+	}
+}
+
+func (rs *RankSet) Update(userID, score int32) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.scores[userID] = score
+	rs.dirty[userID] = true
+}
+
+func (rs *RankSet) Delete(userID int32) {
+	rs.Lock()
+	defer rs.Unlock()
+	delete(rs.scores, userID)
+	rs.dirty[userID] = false
+}
+
+// CompareAndUpdate sets score to newScore only if the current stored score
+// for userID still equals expectedScore, returning false without changing
+// anything on mismatch. A user with no stored score has an expected score
+// of 0, so a CAS against 0 also covers first-write — but that also means a
+// missing entry and a real score of 0 are indistinguishable to the caller,
+// so ExpectedScore: 0 can clobber a legitimate 0-score row. Callers that
+// need to tell the two apart require a per-user version stamp instead of a
+// score comparison; see the call site in AtomicRankChange.
+func (rs *RankSet) CompareAndUpdate(userID, expectedScore, newScore int32) bool {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.scores[userID] != expectedScore {
+		return false
+	}
+	rs.scores[userID] = newScore
+	rs.dirty[userID] = true
+	return true
+}
+
+// Score returns the current score for userID.
+func (rs *RankSet) Score(userID int32) (int32, bool) {
+	rs.RLock()
+	defer rs.RUnlock()
+	score, ok := rs.scores[userID]
+	return score, ok
+}
+
+// TakeDirty drains and returns the userIDs changed since the last call,
+// mapping each to whether it is still alive (true) or was deleted (false).
+func (rs *RankSet) TakeDirty() map[int32]bool {
+	rs.Lock()
+	defer rs.Unlock()
+	dirty := rs.dirty
+	rs.dirty = make(map[int32]bool)
+	return dirty
+}
+
+// Restore sets a score loaded from persistence without marking it dirty.
+func (rs *RankSet) Restore(userID, score int32) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.scores[userID] = score
+}
+
+func (rs *RankSet) Rank(userID int32) (rank int32, score int32) {
+	rs.RLock()
+	defer rs.RUnlock()
+	score, ok := rs.scores[userID]
+	if !ok {
+		return 0, 0
+	}
+	for id, s := range rs.scores {
+		if id != userID && s > score {
+			rank++
+		}
+	}
+	return rank + 1, score
+}
+
+func (rs *RankSet) GetList(a, b int) (ids []int32, scores []int32) {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	type entry struct {
+		id    int32
+		score int32
+	}
+	list := make([]entry, 0, len(rs.scores))
+	for id, score := range rs.scores {
+		list = append(list, entry{id, score})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].score > list[j].score })
+
+	if a < 0 {
+		a = 0
+	}
+	if b > len(list) {
+		b = len(list)
+	}
+	if a >= b {
+		return nil, nil
+	}
+
+	for _, e := range list[a:b] {
+		ids = append(ids, e.id)
+		scores = append(scores, e.score)
+	}
+	return ids, scores
+}
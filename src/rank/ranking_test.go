@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestRankSetCompareAndUpdate(t *testing.T) {
+	rs := NewRankSet()
+
+	// First write: no entry yet, so ExpectedScore 0 succeeds.
+	if !rs.CompareAndUpdate(1, 0, 100) {
+		t.Fatal("first write with ExpectedScore 0 should succeed")
+	}
+	if score, ok := rs.Score(1); !ok || score != 100 {
+		t.Fatalf("got score %d, %v; want 100, true", score, ok)
+	}
+
+	// Mismatch: stale caller still thinks the score is 0.
+	if rs.CompareAndUpdate(1, 0, 200) {
+		t.Fatal("CAS against a stale expected score should fail")
+	}
+	if score, _ := rs.Score(1); score != 100 {
+		t.Fatalf("mismatched CAS must not change the stored score, got %d", score)
+	}
+
+	// Hit: caller has the current score.
+	if !rs.CompareAndUpdate(1, 100, 200) {
+		t.Fatal("CAS against the current score should succeed")
+	}
+	if score, _ := rs.Score(1); score != 200 {
+		t.Fatalf("got score %d; want 200", score)
+	}
+}
+
+func TestRankSetTakeDirty(t *testing.T) {
+	rs := NewRankSet()
+	rs.Update(1, 10)
+	rs.Update(2, 20)
+	rs.Delete(2)
+
+	dirty := rs.TakeDirty()
+	if alive, ok := dirty[1]; !ok || !alive {
+		t.Fatalf("user 1 should be dirty and alive, got %v, %v", alive, ok)
+	}
+	if alive, ok := dirty[2]; !ok || alive {
+		t.Fatalf("user 2 should be dirty and deleted, got %v, %v", alive, ok)
+	}
+
+	// TakeDirty drains the set; a second call with no new changes is empty.
+	if dirty = rs.TakeDirty(); len(dirty) != 0 {
+		t.Fatalf("TakeDirty should drain, got %v", dirty)
+	}
+}
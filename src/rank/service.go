@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	log "github.com/GameGophers/libs/nsq-logger"
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 	"golang.org/x/net/context"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -21,28 +27,196 @@ const (
 )
 
 const (
-	BOLTDB_FILE    = "/data/RANK-DUMP.DAT"
+	BOLTDB_DIR     = "/data/rank"
 	BOLTDB_BUCKET  = "RANKING"
 	CHANGES_SIZE   = 65536
 	CHECK_INTERVAL = 10 * time.Second // if ranking has changed, how long to check
+
+	DEFAULT_MAX_BATCH_SIZE  = 1000
+	DEFAULT_MAX_BATCH_DELAY = 10 * time.Millisecond
+
+	DB_IDLE_TTL            = 5 * time.Minute // close a rankset's db file after this long unused
+	DB_IDLE_CHECK_INTERVAL = 1 * time.Minute // how often to sweep for idle db files
+	OPEN_DB_TIMEOUT        = 5 * time.Second // bounded flock wait when opening a rankset file
+)
+
+const (
+	WINDOW_DAILY         = "DAILY"
+	WINDOW_WEEKLY        = "WEEKLY"
+	WINDOW_SEASON_PREFIX = "SEASON:"
+
+	ROTATE_CHECK_INTERVAL     = 1 * time.Minute
+	DEFAULT_RETENTION_WINDOWS = 8 // server.RetentionWindows falls back to this many most recent windows per rotating leaderboard
+
+	// META_BUCKET holds a rotating leaderboard's own metadata, alongside its
+	// window buckets, so the Window spec survives a restart.
+	META_BUCKET     = "__meta__"
+	META_KEY_WINDOW = "window"
 )
 
+// dbHandle is one open bbolt file for a single rankset, plus the bookkeeping
+// needed to evict it once it has been idle for DB_IDLE_TTL.
+type dbHandle struct {
+	db       *bolt.DB
+	lastUsed time.Time
+}
+
 var (
 	OK                    = &Ranking_NullResult{}
 	ERROR_NAME_NOT_EXISTS = errors.New("name not exists")
+	ERROR_SCORE_MODIFIED  = errors.New("score modified")
 )
 
 type server struct {
-	ranks   map[string]*RankSet
-	pending chan string
+	ranks     map[string]*RankSet
+	pending   chan string
+	deletions chan string
+
+	// windows holds each rotating leaderboard's Window spec (DAILY, WEEKLY,
+	// or "SEASON:<id>"), keyed by leaderboard name. A leaderboard absent
+	// here has no rotation and behaves exactly as before.
+	windows map[string]string
+
+	// DBDir holds one bbolt file per rankset, named "<name>.dat". Falls
+	// back to BOLTDB_DIR when unset.
+	DBDir  string
+	dbs    map[string]*dbHandle
+	dbsMtx sync.Mutex
+
+	// bbolt batching/durability knobs; zero values fall back to the
+	// DEFAULT_MAX_BATCH_* constants, NoSync/NoFreelistSync default to false.
+	MaxBatchSize   int
+	MaxBatchDelay  time.Duration
+	NoSync         bool
+	NoFreelistSync bool
+
+	// RetentionWindows caps how many of the most recent past window buckets
+	// prune_windows keeps per rotating leaderboard, in addition to the
+	// currently active window, which is never pruned; a zero value falls back
+	// to DEFAULT_RETENTION_WINDOWS, a negative value keeps every window.
+	RetentionWindows int
+
 	sync.RWMutex
 }
 
 func (s *server) init() {
 	s.ranks = make(map[string]*RankSet)
 	s.pending = make(chan string, CHANGES_SIZE)
+	s.deletions = make(chan string, CHANGES_SIZE)
+	s.windows = make(map[string]string)
+	s.dbs = make(map[string]*dbHandle)
+	if s.DBDir == "" {
+		s.DBDir = BOLTDB_DIR
+	}
+	if s.MaxBatchSize == 0 {
+		s.MaxBatchSize = DEFAULT_MAX_BATCH_SIZE
+	}
+	if s.MaxBatchDelay == 0 {
+		s.MaxBatchDelay = DEFAULT_MAX_BATCH_DELAY
+	}
+	if s.RetentionWindows == 0 {
+		s.RetentionWindows = DEFAULT_RETENTION_WINDOWS
+	}
 	s.restore()
 	go s.persistence_task()
+	go s.close_idle_dbs()
+	go s.rotate_task()
+}
+
+// register_window records name's rotation policy the first time a caller
+// supplies one, persisting it to name's own db so a restart doesn't forget
+// it, and returns whichever policy is on file for subsequent calls that
+// omit it (so only the first RankChange for a leaderboard needs to set
+// Window).
+func (s *server) register_window(name, window string) string {
+	if window != "" {
+		var changed bool
+		s.lock_write(func() {
+			if s.windows[name] != window {
+				s.windows[name] = window
+				changed = true
+			}
+		})
+		if changed {
+			if err := s.persist_window(name, window); err != nil {
+				log.Warning("persist window spec:", name, err)
+			}
+		}
+		return window
+	}
+	var existing string
+	s.lock_read(func() {
+		existing = s.windows[name]
+	})
+	return existing
+}
+
+// persist_window writes name's Window spec into its own db file's metadata
+// bucket so restore can repopulate s.windows after a restart.
+func (s *server) persist_window(name, window string) error {
+	db, err := s.get_db(name)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(META_BUCKET))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(META_KEY_WINDOW), []byte(window))
+	})
+}
+
+// windowEpoch returns the bucket suffix for the window active at t: a UTC
+// day stamp for DAILY, an ISO (year, week) stamp for WEEKLY, or the season
+// id verbatim for "SEASON:<id>" (seasons are rotated by the caller picking
+// a new id, not by wall-clock). An empty/unrecognized window returns "".
+func windowEpoch(window string, t time.Time) string {
+	switch {
+	case window == WINDOW_DAILY:
+		return t.UTC().Format("20060102")
+	case window == WINDOW_WEEKLY:
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%04dW%02d", year, week)
+	case strings.HasPrefix(window, WINDOW_SEASON_PREFIX):
+		return strings.TrimPrefix(window, WINDOW_SEASON_PREFIX)
+	default:
+		return ""
+	}
+}
+
+// rankKey returns the key used in s.ranks for name under window, active at
+// the current time: name itself when there's no rotation, or
+// "<name>/<epoch>" for the currently active window.
+func rankKey(name, window string) string {
+	epoch := windowEpoch(window, time.Now())
+	if epoch == "" {
+		return name
+	}
+	return name + "/" + epoch
+}
+
+// splitRankKey reverses rankKey, returning the on-disk leaderboard file
+// (base) and bucket name for a rank key.
+func splitRankKey(key string) (base string, bucket string) {
+	if idx := strings.LastIndex(key, "/"); idx >= 0 {
+		return key[:idx], key[idx+1:]
+	}
+	return key, BOLTDB_BUCKET
+}
+
+// resolveWindowKey returns the rank key for name at a specific historical
+// epoch, or, when epoch is empty, the key for whichever window is
+// currently active under name's registered rotation policy (if any).
+func (s *server) resolveWindowKey(name, epoch string) string {
+	if epoch != "" {
+		return name + "/" + epoch
+	}
+	var window string
+	s.lock_read(func() {
+		window = s.windows[name]
+	})
+	return rankKey(name, window)
 }
 
 func (s *server) lock_read(f func()) {
@@ -58,26 +232,65 @@ func (s *server) lock_write(f func()) {
 }
 
 func (s *server) RankChange(ctx context.Context, p *Ranking_Change) (*Ranking_NullResult, error) {
+	window := s.register_window(p.Name, p.Window)
+	key := rankKey(p.Name, window)
+
 	// check name existence
 	var rs *RankSet
 	s.lock_write(func() {
-		rs = s.ranks[p.Name]
+		rs = s.ranks[key]
 		if rs == nil {
 			rs = NewRankSet()
-			s.ranks[p.Name] = rs
+			s.ranks[key] = rs
 		}
 	})
 
 	// apply update on the rankset
 	rs.Update(p.UserId, p.Score)
-	s.pending <- p.Name
+	s.pending <- key
+	return OK, nil
+}
+
+// AtomicRankChange applies a compare-and-swap update: the write only takes
+// effect if the user's current score still matches ExpectedScore, so
+// multiple game servers can race "set-if-higher"/"increment-if-unchanged"
+// updates against the same leaderboard without a coordinating mutex of
+// their own. A mismatch returns ERROR_SCORE_MODIFIED rather than clobbering
+// a concurrent writer's update. Note ExpectedScore is the score itself, not
+// a version stamp: it cannot distinguish "no entry yet" from "entry at
+// exactly 0", so a first-write CAS with ExpectedScore 0 can also overwrite a
+// real 0-score row (see RankSet.CompareAndUpdate).
+func (s *server) AtomicRankChange(ctx context.Context, p *Ranking_AtomicChange) (*Ranking_NullResult, error) {
+	window := s.register_window(p.Name, p.Window)
+	key := rankKey(p.Name, window)
+
+	var rs *RankSet
+	s.lock_write(func() {
+		rs = s.ranks[key]
+		if rs == nil {
+			rs = NewRankSet()
+			s.ranks[key] = rs
+		}
+	})
+
+	if !rs.CompareAndUpdate(p.UserId, p.ExpectedScore, p.NewScore) {
+		return nil, ERROR_SCORE_MODIFIED
+	}
+
+	s.pending <- key
 	return OK, nil
 }
 
+// QueryRankRange reads name's current rank range. A board rotating under
+// register_window has no bare-name entry in s.ranks, so this resolves to
+// whichever window is currently active, the same fallback QueryRankRangeWindow
+// uses for an empty Epoch; a non-rotating board is unaffected.
 func (s *server) QueryRankRange(ctx context.Context, p *Ranking_Range) (*Ranking_RankList, error) {
+	key := s.resolveWindowKey(p.Name, "")
+
 	var rs *RankSet
 	s.lock_read(func() {
-		rs = s.ranks[p.Name]
+		rs = s.ranks[key]
 	})
 
 	if rs == nil {
@@ -88,10 +301,14 @@ func (s *server) QueryRankRange(ctx context.Context, p *Ranking_Range) (*Ranking
 	return &Ranking_RankList{UserIds: ids, Scores: cups}, nil
 }
 
+// QueryUsers reads name's current ranks for a set of users, resolving to the
+// active window the same way QueryRankRange does.
 func (s *server) QueryUsers(ctx context.Context, p *Ranking_Users) (*Ranking_UserList, error) {
+	key := s.resolveWindowKey(p.Name, "")
+
 	var rs *RankSet
 	s.lock_read(func() {
-		rs = s.ranks[p.Name]
+		rs = s.ranks[key]
 	})
 
 	if rs == nil {
@@ -108,30 +325,194 @@ func (s *server) QueryUsers(ctx context.Context, p *Ranking_Users) (*Ranking_Use
 	return &Ranking_UserList{Ranks: ranks, Scores: scores}, nil
 }
 
+// QueryRankRangeWindow reads a historical window of a rotating leaderboard.
+// An empty Epoch falls back to the currently active window, so existing
+// non-rotating callers of QueryRankRange are unaffected.
+func (s *server) QueryRankRangeWindow(ctx context.Context, p *Ranking_RangeWindow) (*Ranking_RankList, error) {
+	key := s.resolveWindowKey(p.Name, p.Epoch)
+
+	var rs *RankSet
+	s.lock_read(func() {
+		rs = s.ranks[key]
+	})
+	if rs == nil {
+		return nil, ERROR_NAME_NOT_EXISTS
+	}
+
+	ids, cups := rs.GetList(int(p.A), int(p.B))
+	return &Ranking_RankList{UserIds: ids, Scores: cups}, nil
+}
+
+// QueryUsersWindow is QueryUsers scoped to a historical window of a rotating
+// leaderboard; an empty Epoch means the currently active window.
+func (s *server) QueryUsersWindow(ctx context.Context, p *Ranking_UsersWindow) (*Ranking_UserList, error) {
+	key := s.resolveWindowKey(p.Name, p.Epoch)
+
+	var rs *RankSet
+	s.lock_read(func() {
+		rs = s.ranks[key]
+	})
+	if rs == nil {
+		return nil, ERROR_NAME_NOT_EXISTS
+	}
+
+	ranks := make([]int32, 0, len(p.UserIds))
+	scores := make([]int32, 0, len(p.UserIds))
+	for _, id := range p.UserIds {
+		rank, score := rs.Rank(id)
+		ranks = append(ranks, rank)
+		scores = append(scores, score)
+	}
+	return &Ranking_UserList{Ranks: ranks, Scores: scores}, nil
+}
+
+// Snapshot streams a byte-for-byte consistent copy of a rankset to p.Path
+// using a read transaction, so operators can take a hot backup or
+// point-in-time export without stopping the service or racing a live
+// persistence_task flush. With Epoch set, only that one window bucket is
+// copied into a fresh db file rather than Name's whole file; with Epoch
+// empty, Name's whole file (or, with Name also empty, every loaded
+// leaderboard's whole file) is copied via Tx.WriteTo.
+func (s *server) Snapshot(ctx context.Context, p *Ranking_SnapshotRequest) (*Ranking_SnapshotResult, error) {
+	if p.Epoch != "" {
+		return s.snapshot_bucket(p.Name, p.Epoch, p.Path)
+	}
+
+	var names []string
+	if p.Name != "" {
+		names = []string{p.Name}
+	} else {
+		seen := make(map[string]bool)
+		s.lock_read(func() {
+			for key := range s.ranks {
+				base, _ := splitRankKey(key)
+				if !seen[base] {
+					seen[base] = true
+					names = append(names, base)
+				}
+			}
+		})
+	}
+
+	if err := os.MkdirAll(p.Path, 0700); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		db, err := s.get_db(name)
+		if err != nil {
+			return nil, err
+		}
+
+		dst := filepath.Join(p.Path, name+".dat")
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteTo(f)
+			return err
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, dst)
+	}
+
+	return &Ranking_SnapshotResult{Paths: paths}, nil
+}
+
+// snapshot_bucket copies just one window bucket of a rotating leaderboard
+// into a fresh bbolt file "<name>.<epoch>.dat" under the directory path,
+// instead of dragging along every other window the way a whole-file
+// Tx.WriteTo would. An earlier snapshot file of the same name is replaced.
+func (s *server) snapshot_bucket(name, epoch, path string) (*Ranking_SnapshotResult, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	src, err := s.get_db(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := filepath.Join(path, name+"."+epoch+".dat")
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	out, err := bolt.Open(dst, 0600, &bolt.Options{Timeout: OPEN_DB_TIMEOUT})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	err = src.View(func(srcTx *bolt.Tx) error {
+		b := srcTx.Bucket([]byte(epoch))
+		if b == nil {
+			return ERROR_NAME_NOT_EXISTS
+		}
+
+		return out.Update(func(dstTx *bolt.Tx) error {
+			dstBucket, err := dstTx.CreateBucketIfNotExists([]byte(epoch))
+			if err != nil {
+				return err
+			}
+
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if err := dstBucket.Put(k, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ranking_SnapshotResult{Paths: []string{dst}}, nil
+}
+
 func (s *server) DeleteSet(ctx context.Context, p *Ranking_SetName) (*Ranking_NullResult, error) {
 	s.lock_write(func() {
 		delete(s.ranks, p.Name)
+		prefix := p.Name + "/"
+		for key := range s.ranks {
+			if strings.HasPrefix(key, prefix) {
+				delete(s.ranks, key)
+			}
+		}
+		delete(s.windows, p.Name)
 	})
+	s.deletions <- p.Name
 	return OK, nil
 }
 
 func (s *server) DeleteUser(ctx context.Context, p *Ranking_UserId) (*Ranking_NullResult, error) {
+	key := s.resolveWindowKey(p.Name, "")
+
 	var rs *RankSet
 	s.lock_read(func() {
-		rs = s.ranks[p.Name]
+		rs = s.ranks[key]
 	})
 	if rs == nil {
 		return nil, ERROR_NAME_NOT_EXISTS
 	}
 	rs.Delete(p.UserId)
+	s.pending <- key
 	return OK, nil
 }
 
 // persistence ranking tree into db
 func (s *server) persistence_task() {
 	timer := time.After(CHECK_INTERVAL)
-	db := s.open_db()
 	changes := make(map[string]bool)
+	deletedSets := make(map[string]bool)
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 
@@ -139,83 +520,301 @@ func (s *server) persistence_task() {
 		select {
 		case key := <-s.pending:
 			changes[key] = true
+		case key := <-s.deletions:
+			delete(changes, key)
+			deletedSets[key] = true
 		case <-timer:
-			s.dump_changes(db, changes)
+			s.dump_changes(changes, deletedSets)
 			log.Infof("perisisted %v rankset:", len(changes))
 			changes = make(map[string]bool)
+			deletedSets = make(map[string]bool)
 			timer = time.After(CHECK_INTERVAL)
 		case <-sig:
-			s.dump_changes(db, changes)
-			db.Close()
+			s.dump_changes(changes, deletedSets)
+			s.close_all_dbs()
 			log.Info("SIGTERM")
 			os.Exit(0)
 		}
 	}
 }
 
-func (s *server) dump_changes(db *bolt.DB, changes map[string]bool) {
-	for k := range changes {
-		// marshal
+// dump_changes flushes only the rows that changed since the last tick. Each
+// leaderboard lives in its own bbolt file, with one bucket per rankKey
+// (BOLTDB_BUCKET when it isn't rotating, or its window epoch when it is),
+// so each leaderboard file gets its own Batch call; dirty userIDs are a
+// single Put (alive) or Delete (removed), keyed by little-endian int32 id.
+func (s *server) dump_changes(changes map[string]bool, deletedSets map[string]bool) {
+	for key := range deletedSets {
+		base, _ := splitRankKey(key)
+		s.delete_db_file(base)
+	}
+
+	for key := range changes {
 		var rs *RankSet
 		s.lock_read(func() {
-			rs = s.ranks[k]
+			rs = s.ranks[key]
 		})
 		if rs == nil {
-			log.Warning("empty rankset:", k)
+			log.Warning("empty rankset:", key)
+			continue
+		}
+
+		dirty := rs.TakeDirty()
+		if len(dirty) == 0 {
 			continue
 		}
 
-		// serialization and save
-		bin, err := rs.Marshal()
+		base, bucket := splitRankKey(key)
+		db, err := s.get_db(base)
 		if err != nil {
-			log.Critical("cannot marshal:", err)
-			os.Exit(-1)
+			log.Critical("open rankset db:", base, err)
+			continue
 		}
 
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(BOLTDB_BUCKET))
-			err := b.Put([]byte(k), bin)
-			return err
+		err = db.Batch(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+
+			rowKey := make([]byte, 4)
+			for userID, alive := range dirty {
+				binary.LittleEndian.PutUint32(rowKey, uint32(userID))
+
+				if !alive {
+					if err := b.Delete(rowKey); err != nil {
+						return err
+					}
+					continue
+				}
+
+				score, ok := rs.Score(userID)
+				if !ok {
+					continue
+				}
+				val := make([]byte, 4)
+				binary.LittleEndian.PutUint32(val, uint32(score))
+				if err := b.Put(rowKey, val); err != nil {
+					return err
+				}
+			}
+			return nil
 		})
+		if err != nil {
+			log.Critical("batch persist failed:", key, err)
+		}
 	}
 }
 
-func (s *server) open_db() *bolt.DB {
-	db, err := bolt.Open(BOLTDB_FILE, 0600, nil)
+func (s *server) rankset_path(name string) string {
+	return filepath.Join(s.DBDir, name+".dat")
+}
+
+// get_db returns the open handle for name's bbolt file, opening it (with a
+// bounded flock wait) on first access and refreshing its idle deadline.
+func (s *server) get_db(name string) (*bolt.DB, error) {
+	s.dbsMtx.Lock()
+	defer s.dbsMtx.Unlock()
+
+	if h, ok := s.dbs[name]; ok {
+		h.lastUsed = time.Now()
+		return h.db, nil
+	}
+
+	db, err := bolt.Open(s.rankset_path(name), 0600, &bolt.Options{Timeout: OPEN_DB_TIMEOUT})
 	if err != nil {
-		log.Critical(err)
+		return nil, err
+	}
+	db.MaxBatchSize = s.MaxBatchSize
+	db.MaxBatchDelay = s.MaxBatchDelay
+	db.NoSync = s.NoSync
+	db.NoFreelistSync = s.NoFreelistSync
+
+	s.dbs[name] = &dbHandle{db: db, lastUsed: time.Now()}
+	return db, nil
+}
+
+// close_idle_dbs periodically evicts rankset db handles that have not been
+// touched in DB_IDLE_TTL, so hosting thousands of leaderboards in one
+// process doesn't require thousands of file descriptors held open at once.
+func (s *server) close_idle_dbs() {
+	for range time.Tick(DB_IDLE_CHECK_INTERVAL) {
+		s.dbsMtx.Lock()
+		for name, h := range s.dbs {
+			if time.Since(h.lastUsed) > DB_IDLE_TTL {
+				h.db.Close()
+				delete(s.dbs, name)
+			}
+		}
+		s.dbsMtx.Unlock()
+	}
+}
+
+func (s *server) close_all_dbs() {
+	s.dbsMtx.Lock()
+	defer s.dbsMtx.Unlock()
+	for name, h := range s.dbs {
+		h.db.Close()
+		delete(s.dbs, name)
+	}
+}
+
+// delete_db_file closes a rankset's handle, if open, and removes its file so
+// deleting a leaderboard is as cheap as removing a file.
+func (s *server) delete_db_file(name string) {
+	s.dbsMtx.Lock()
+	if h, ok := s.dbs[name]; ok {
+		h.db.Close()
+		delete(s.dbs, name)
+	}
+	s.dbsMtx.Unlock()
+
+	if err := os.Remove(s.rankset_path(name)); err != nil && !os.IsNotExist(err) {
+		log.Warning("remove rankset file:", name, err)
+	}
+}
+
+// restore rebuilds every rank set from its own file under DBDir. A
+// non-rotating leaderboard has a single BOLTDB_BUCKET bucket restored under
+// its own name; a rotating one has one bucket per window epoch, each
+// restored under "<name>/<epoch>" so historical windows stay queryable
+// across a restart, plus a META_BUCKET this loop restores into s.windows
+// instead of s.ranks so register_window doesn't forget the rotation policy.
+func (s *server) restore() {
+	if err := os.MkdirAll(s.DBDir, 0700); err != nil {
+		log.Critical("create rankset dir:", err)
 		os.Exit(-1)
 	}
-	// create bulket
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(BOLTDB_BUCKET))
+
+	entries, err := ioutil.ReadDir(s.DBDir)
+	if err != nil {
+		log.Critical("read rankset dir:", err)
+		os.Exit(-1)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".dat" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".dat")
+
+		db, err := s.get_db(name)
 		if err != nil {
-			log.Criticalf("create bucket: %s", err)
+			log.Critical("open rankset db:", name, err)
 			os.Exit(-1)
 		}
-		return nil
-	})
-	return db
-}
 
-func (s *server) restore() {
-	// restore data from db file
-	db := s.open_db()
-	defer db.Close()
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BOLTDB_BUCKET))
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			rs := NewRankSet()
-			err := rs.Unmarshal(v)
-			if err != nil {
-				log.Critical("rank data corrupted:", err)
-				os.Exit(-1)
-			}
-			s.ranks[string(k)] = rs
+		var window string
+		db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(bucketName []byte, b *bolt.Bucket) error {
+				if string(bucketName) == META_BUCKET {
+					if v := b.Get([]byte(META_KEY_WINDOW)); v != nil {
+						window = string(v)
+					}
+					return nil
+				}
+
+				rs := NewRankSet()
+				c := b.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					userID := int32(binary.LittleEndian.Uint32(k))
+					score := int32(binary.LittleEndian.Uint32(v))
+					rs.Restore(userID, score)
+				}
+
+				bucket := string(bucketName)
+				key := name
+				if bucket != BOLTDB_BUCKET {
+					key = name + "/" + bucket
+				}
+				s.ranks[key] = rs
+				return nil
+			})
+		})
+
+		if window != "" {
+			s.windows[name] = window
 		}
+	}
+}
 
-		return nil
+// rotate_task periodically prunes every rotating leaderboard down to its
+// active window plus its RetentionWindows most recent past window buckets.
+// Sealing is implicit:
+// RankChange only ever writes to the bucket for the currently active
+// epoch, so an older window is already read-only the moment rotation
+// passes it by. This goroutine's only job is retention cleanup.
+func (s *server) rotate_task() {
+	for range time.Tick(ROTATE_CHECK_INTERVAL) {
+		s.prune_windows()
+	}
+}
+
+// prune_windows keeps only the currently active window bucket and the
+// RetentionWindows most recent other window buckets for each rotating
+// leaderboard, deleting the rest. This is a count-based
+// cutoff, not an age-based one: a leaderboard with long gaps between writes
+// can still keep windows that are individually old, so long as fewer than
+// RetentionWindows newer ones exist.
+func (s *server) prune_windows() {
+	if s.RetentionWindows < 0 {
+		return
+	}
+
+	windows := make(map[string]string)
+	s.lock_read(func() {
+		for name, window := range s.windows {
+			windows[name] = window
+		}
 	})
+
+	for name, window := range windows {
+		if strings.HasPrefix(window, WINDOW_SEASON_PREFIX) {
+			// Season ids are caller-chosen, not chronological, so a
+			// lexicographic sort can't safely pick the "oldest" one
+			// (e.g. "10" < "9") — season boards are pruned manually
+			// instead of by this retention sweep.
+			continue
+		}
+
+		current := windowEpoch(window, time.Now())
+
+		db, err := s.get_db(name)
+		if err != nil {
+			log.Warning("rotate: open rankset db:", name, err)
+			continue
+		}
+
+		var epochs [][]byte
+		db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(bucketName []byte, _ *bolt.Bucket) error {
+				bucket := string(bucketName)
+				if bucket != current && bucket != META_BUCKET {
+					epochs = append(epochs, append([]byte(nil), bucketName...))
+				}
+				return nil
+			})
+		})
+		if len(epochs) <= s.RetentionWindows {
+			continue
+		}
+
+		sort.Slice(epochs, func(i, j int) bool { return string(epochs[i]) < string(epochs[j]) })
+		stale := epochs[:len(epochs)-s.RetentionWindows]
+
+		db.Update(func(tx *bolt.Tx) error {
+			for _, epoch := range stale {
+				if err := tx.DeleteBucket(epoch); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			return nil
+		})
+
+		s.lock_write(func() {
+			for _, epoch := range stale {
+				delete(s.ranks, name+"/"+string(epoch))
+			}
+		})
+	}
 }
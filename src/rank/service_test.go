@@ -0,0 +1,114 @@
+package main
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"testing"
+)
+
+func newTestServer(dir string) *server {
+	return &server{
+		ranks:   make(map[string]*RankSet),
+		windows: make(map[string]string),
+		dbs:     make(map[string]*dbHandle),
+		DBDir:   dir,
+	}
+}
+
+func TestRestoreRoundTripNonRotating(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestServer(dir)
+	rs := NewRankSet()
+	rs.Update(7, 42)
+	s1.ranks["board"] = rs
+	s1.dump_changes(map[string]bool{"board": true}, nil)
+	s1.close_all_dbs()
+
+	s2 := newTestServer(dir)
+	s2.restore()
+
+	got, ok := s2.ranks["board"]
+	if !ok {
+		t.Fatal("restore did not repopulate \"board\"")
+	}
+	if score, ok := got.Score(7); !ok || score != 42 {
+		t.Fatalf("got score %d, %v; want 42, true", score, ok)
+	}
+}
+
+func TestPruneWindowsRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	s := newTestServer(dir)
+	s.windows["board"] = WINDOW_DAILY
+	s.RetentionWindows = 2
+
+	epochs := []string{"20200101", "20200102", "20200103", "20200104", "20200105"}
+	db, err := s.get_db("board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, epoch := range epochs {
+			if _, err := tx.CreateBucketIfNotExists([]byte(epoch)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, epoch := range epochs {
+		s.ranks["board/"+epoch] = NewRankSet()
+	}
+
+	s.prune_windows()
+
+	// RetentionWindows is count-based: only the lexicographically largest
+	// (most recent, for DAILY's fixed-width stamps) epochs survive.
+	kept := map[string]bool{"20200104": true, "20200105": true}
+	db.View(func(tx *bolt.Tx) error {
+		for _, epoch := range epochs {
+			exists := tx.Bucket([]byte(epoch)) != nil
+			if exists != kept[epoch] {
+				t.Errorf("bucket %q exists=%v, want %v", epoch, exists, kept[epoch])
+			}
+		}
+		return nil
+	})
+	for _, epoch := range epochs {
+		_, ok := s.ranks["board/"+epoch]
+		if ok != kept[epoch] {
+			t.Errorf("s.ranks[%q] present=%v, want %v", "board/"+epoch, ok, kept[epoch])
+		}
+	}
+}
+
+func TestRestoreRoundTripRotating(t *testing.T) {
+	dir := t.TempDir()
+
+	s1 := newTestServer(dir)
+	window := s1.register_window("board", WINDOW_DAILY)
+	key := rankKey("board", window)
+
+	rs := NewRankSet()
+	rs.Update(7, 42)
+	s1.ranks[key] = rs
+	s1.dump_changes(map[string]bool{key: true}, nil)
+	s1.close_all_dbs()
+
+	s2 := newTestServer(dir)
+	s2.restore()
+
+	if s2.windows["board"] != WINDOW_DAILY {
+		t.Fatalf("got window %q; want %q", s2.windows["board"], WINDOW_DAILY)
+	}
+	got, ok := s2.ranks[key]
+	if !ok {
+		t.Fatalf("restore did not repopulate %q", key)
+	}
+	if score, ok := got.Score(7); !ok || score != 42 {
+		t.Fatalf("got score %d, %v; want 42, true", score, ok)
+	}
+}